@@ -0,0 +1,160 @@
+package main
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ryanmab/rdap-go/pkg/client/response/dns"
+)
+
+// defaultCacheSize bounds the number of domains the response cache will
+// hold before evicting the least recently used entry.
+const defaultCacheSize = 4096
+
+// defaultPositiveTTL and defaultNegativeTTL are the cache lifetimes used
+// when --cache-ttl/--cache-negative-ttl are not given. Negative results are
+// kept for much less time since a domain that just failed to resolve may
+// start working again soon (e.g. a newly registered domain).
+const (
+	defaultPositiveTTL = 5 * time.Minute
+	defaultNegativeTTL = 30 * time.Second
+)
+
+// CacheStats holds the Prometheus-style counters exposed for the response
+// cache. All fields are updated with atomic operations and may be read
+// concurrently.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Coalesced uint64
+}
+
+// cacheEntry is a single cached RDAP lookup result, positive or negative.
+type cacheEntry struct {
+	resp    *dns.Response
+	err     error
+	expires time.Time
+	elem    *list.Element
+}
+
+func (e *cacheEntry) expired(now time.Time) bool {
+	return now.After(e.expires)
+}
+
+// call represents an in-flight upstream query. Goroutines that ask for the
+// same domain while a call is outstanding wait on wg instead of issuing
+// their own upstream request, mirroring the "Inflight" coalescing miekg/dns
+// uses in its Client.
+type call struct {
+	wg   sync.WaitGroup
+	resp *dns.Response
+	err  error
+}
+
+// ResponseCache is a bounded, TTL-based LRU cache of RDAP lookup results
+// keyed by normalized domain, with in-flight request coalescing.
+type ResponseCache struct {
+	mu          sync.Mutex
+	entries     map[string]*cacheEntry
+	order       *list.List // front = most recently used
+	capacity    int
+	positiveTTL time.Duration
+	negativeTTL time.Duration
+	inflight    map[string]*call
+
+	Stats CacheStats
+}
+
+// NewResponseCache builds a ResponseCache with the given capacity and TTLs.
+// A capacity of 0 falls back to defaultCacheSize.
+func NewResponseCache(capacity int, positiveTTL, negativeTTL time.Duration) *ResponseCache {
+	if capacity <= 0 {
+		capacity = defaultCacheSize
+	}
+	return &ResponseCache{
+		entries:     make(map[string]*cacheEntry),
+		order:       list.New(),
+		capacity:    capacity,
+		positiveTTL: positiveTTL,
+		negativeTTL: negativeTTL,
+		inflight:    make(map[string]*call),
+	}
+}
+
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+}
+
+// Lookup returns the cached result for domain if present and unexpired.
+// Otherwise it calls fetch exactly once even if multiple goroutines ask for
+// the same domain concurrently, caches the result (positive or negative),
+// and returns it to every waiter.
+func (c *ResponseCache) Lookup(domain string, fetch func() (*dns.Response, error)) (*dns.Response, error) {
+	key := normalizeDomain(domain)
+	now := time.Now()
+
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok && !entry.expired(now) {
+		c.order.MoveToFront(entry.elem)
+		c.mu.Unlock()
+		atomic.AddUint64(&c.Stats.Hits, 1)
+		return entry.resp, entry.err
+	}
+
+	if inFlight, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		atomic.AddUint64(&c.Stats.Coalesced, 1)
+		inFlight.wg.Wait()
+		return inFlight.resp, inFlight.err
+	}
+
+	in := &call{}
+	in.wg.Add(1)
+	c.inflight[key] = in
+	c.mu.Unlock()
+
+	atomic.AddUint64(&c.Stats.Misses, 1)
+	resp, err := fetch()
+	in.resp, in.err = resp, err
+	in.wg.Done()
+
+	ttl := c.positiveTTL
+	if err != nil {
+		ttl = c.negativeTTL
+	}
+	c.store(key, resp, err, ttl)
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	c.mu.Unlock()
+
+	return resp, err
+}
+
+func (c *ResponseCache) store(key string, resp *dns.Response, err error, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[key]; ok {
+		entry.resp, entry.err = resp, err
+		entry.expires = time.Now().Add(ttl)
+		c.order.MoveToFront(entry.elem)
+		return
+	}
+
+	entry := &cacheEntry{resp: resp, err: err, expires: time.Now().Add(ttl)}
+	entry.elem = c.order.PushFront(key)
+	c.entries[key] = entry
+
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(string))
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ryanmab/rdap-go/pkg/client/response/dns"
+)
+
+func TestResponseCacheHitAndMiss(t *testing.T) {
+	c := NewResponseCache(10, time.Minute, time.Minute)
+	want := &dns.Response{}
+
+	var calls int
+	fetch := func() (*dns.Response, error) {
+		calls++
+		return want, nil
+	}
+
+	got, err := c.Lookup("Example.COM.", fetch)
+	if err != nil {
+		t.Fatalf("Lookup returned error: %v", err)
+	}
+	if got != want {
+		t.Errorf("Lookup returned %v, want %v", got, want)
+	}
+	if calls != 1 {
+		t.Fatalf("fetch called %d times on miss, want 1", calls)
+	}
+
+	got, err = c.Lookup("example.com", fetch)
+	if err != nil {
+		t.Fatalf("Lookup returned error: %v", err)
+	}
+	if got != want {
+		t.Errorf("Lookup returned %v, want %v", got, want)
+	}
+	if calls != 1 {
+		t.Errorf("fetch called %d times, want 1 (second lookup should hit cache)", calls)
+	}
+	if c.Stats.Hits != 1 {
+		t.Errorf("Stats.Hits = %d, want 1", c.Stats.Hits)
+	}
+	if c.Stats.Misses != 1 {
+		t.Errorf("Stats.Misses = %d, want 1", c.Stats.Misses)
+	}
+}
+
+func TestResponseCacheNegativeTTL(t *testing.T) {
+	wantErr := errors.New("no such domain")
+	c := NewResponseCache(10, time.Minute, time.Millisecond)
+
+	var calls int
+	fetch := func() (*dns.Response, error) {
+		calls++
+		return nil, wantErr
+	}
+
+	_, err := c.Lookup("example.com", fetch)
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("Lookup error = %v, want %v", err, wantErr)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = c.Lookup("example.com", fetch)
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("Lookup error = %v, want %v", err, wantErr)
+	}
+	if calls != 2 {
+		t.Errorf("fetch called %d times, want 2 (negative entry should have expired)", calls)
+	}
+}
+
+func TestResponseCacheCoalescesConcurrentLookups(t *testing.T) {
+	c := NewResponseCache(10, time.Minute, time.Minute)
+	want := &dns.Response{}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var calls int32
+
+	fetch := func() (*dns.Response, error) {
+		calls++
+		close(started)
+		<-release
+		return want, nil
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	results := make([]*dns.Response, 2)
+	go func() {
+		defer wg.Done()
+		res, _ := c.Lookup("example.com", fetch)
+		results[0] = res
+	}()
+
+	<-started
+	go func() {
+		defer wg.Done()
+		res, _ := c.Lookup("example.com", fetch)
+		results[1] = res
+	}()
+
+	// Give the second lookup a chance to join the in-flight call before
+	// the fetch is released.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("fetch called %d times, want 1 (second caller should coalesce)", calls)
+	}
+	for i, res := range results {
+		if res != want {
+			t.Errorf("result[%d] = %v, want %v", i, res, want)
+		}
+	}
+	if c.Stats.Coalesced != 1 {
+		t.Errorf("Stats.Coalesced = %d, want 1", c.Stats.Coalesced)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ryanmab/rdap-go/pkg/client/response/dns"
+)
+
+// OutputFormat selects how a successful RDAP lookup is rendered back to the
+// WHOIS client.
+type OutputFormat string
+
+const (
+	FormatWHOIS OutputFormat = "whois"
+	FormatJSON  OutputFormat = "json"
+	FormatBoth  OutputFormat = "both"
+)
+
+// ParseOutputFormat validates a --format flag value.
+func ParseOutputFormat(s string) (OutputFormat, error) {
+	switch OutputFormat(s) {
+	case FormatWHOIS, FormatJSON, FormatBoth:
+		return OutputFormat(s), nil
+	default:
+		return "", fmt.Errorf("unknown format %q, want whois, json or both", s)
+	}
+}
+
+// upstreamProbe pulls just enough of an RFC 7483 response to identify which
+// server answered it, for logging and diagnostics.
+type upstreamProbe struct {
+	Port43 string `json:"port43"`
+	Links  []struct {
+		Rel  string `json:"rel"`
+		Href string `json:"href"`
+	} `json:"links"`
+}
+
+// upstreamServer returns the RDAP (or legacy WHOIS, via port43) server that
+// produced res, or "" if the response doesn't carry one.
+func upstreamServer(res *dns.Response) string {
+	raw, err := json.Marshal(res)
+	if err != nil {
+		return ""
+	}
+	var p upstreamProbe
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return ""
+	}
+	for _, l := range p.Links {
+		if l.Rel == "self" && l.Href != "" {
+			return l.Href
+		}
+	}
+	return p.Port43
+}
+
+// rdapDomain is the subset of the RFC 7483 domain object used to render
+// WHOIS-style text. It is populated by round-tripping *dns.Response through
+// JSON rather than depending on rdap-go's internal struct layout, since the
+// wire format is the stable, standardized part of the contract.
+type rdapDomain struct {
+	LdhName     string       `json:"ldhName"`
+	Handle      string       `json:"handle"`
+	Status      []string     `json:"status"`
+	Nameservers []rdapServer `json:"nameservers"`
+	Entities    []rdapEntity `json:"entities"`
+	Events      []rdapEvent  `json:"events"`
+	SecureDNS   *struct {
+		DelegationSigned bool `json:"delegationSigned"`
+	} `json:"secureDNS"`
+}
+
+// rdapIPNetwork is the subset of the RFC 7483 "ip network" object used to
+// render WHOIS-style text for IP address and CIDR queries.
+type rdapIPNetwork struct {
+	Handle       string       `json:"handle"`
+	StartAddress string       `json:"startAddress"`
+	EndAddress   string       `json:"endAddress"`
+	IPVersion    string       `json:"ipVersion"`
+	Name         string       `json:"name"`
+	Type         string       `json:"type"`
+	Country      string       `json:"country"`
+	Status       []string     `json:"status"`
+	Entities     []rdapEntity `json:"entities"`
+	Events       []rdapEvent  `json:"events"`
+}
+
+// rdapAutnum is the subset of the RFC 7483 "autnum" object used to render
+// WHOIS-style text for AS number queries.
+type rdapAutnum struct {
+	Handle      string       `json:"handle"`
+	StartAutnum uint32       `json:"startAutnum"`
+	EndAutnum   uint32       `json:"endAutnum"`
+	Name        string       `json:"name"`
+	Type        string       `json:"type"`
+	Status      []string     `json:"status"`
+	Entities    []rdapEntity `json:"entities"`
+	Events      []rdapEvent  `json:"events"`
+}
+
+type rdapServer struct {
+	LdhName string `json:"ldhName"`
+}
+
+// rdapNameserver is the subset of the RFC 7483 "nameserver" object used to
+// render WHOIS-style text for nameserver queries.
+type rdapNameserver struct {
+	LdhName     string   `json:"ldhName"`
+	Handle      string   `json:"handle"`
+	Status      []string `json:"status"`
+	IPAddresses *struct {
+		V4 []string `json:"v4"`
+		V6 []string `json:"v6"`
+	} `json:"ipAddresses"`
+	Entities []rdapEntity `json:"entities"`
+	Events   []rdapEvent  `json:"events"`
+}
+
+type rdapEvent struct {
+	EventAction string `json:"eventAction"`
+	EventDate   string `json:"eventDate"`
+}
+
+type rdapEntity struct {
+	Handle     string          `json:"handle"`
+	Roles      []string        `json:"roles"`
+	VcardArray json.RawMessage `json:"vcardArray"`
+}
+
+// fn returns the entity's formatted name (vCard "fn" property), if present.
+func (e rdapEntity) fn() string {
+	// vcardArray is ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "Example Registrar"], ...]]
+	var card [2]json.RawMessage
+	if err := json.Unmarshal(e.VcardArray, &card); err != nil {
+		return ""
+	}
+	var props [][4]json.RawMessage
+	if err := json.Unmarshal(card[1], &props); err != nil {
+		return ""
+	}
+	for _, prop := range props {
+		var name string
+		if json.Unmarshal(prop[0], &name) == nil && name == "fn" {
+			var value string
+			if json.Unmarshal(prop[3], &value) == nil {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// eppStatusLabels maps a handful of common EPP status codes to the label
+// stock WHOIS clients expect; anything not listed here is passed through.
+var eppStatusLabels = map[string]string{
+	"active":                     "ok",
+	"client transfer prohibited": "clientTransferProhibited",
+	"server transfer prohibited": "serverTransferProhibited",
+	"client delete prohibited":   "clientDeleteProhibited",
+	"server delete prohibited":   "serverDeleteProhibited",
+	"client update prohibited":   "clientUpdateProhibited",
+	"server update prohibited":   "serverUpdateProhibited",
+	"pending delete":             "pendingDelete",
+	"pending transfer":           "pendingTransfer",
+}
+
+func eppStatus(status string) string {
+	if label, ok := eppStatusLabels[status]; ok {
+		return label
+	}
+	return status
+}
+
+// entityWithRole returns the formatted name of the first entity holding
+// role, falling back to its handle.
+func entityWithRole(entities []rdapEntity, role string) string {
+	for _, e := range entities {
+		for _, r := range e.Roles {
+			if r == role {
+				if name := e.fn(); name != "" {
+					return name
+				}
+				return e.Handle
+			}
+		}
+	}
+	return ""
+}
+
+func registrar(entities []rdapEntity) string {
+	return entityWithRole(entities, "registrar")
+}
+
+func eventDate(events []rdapEvent, action string) string {
+	for _, ev := range events {
+		if ev.EventAction == action {
+			return ev.EventDate
+		}
+	}
+	return ""
+}
+
+// objectClass identifies which RFC 7483 object a response carries, via its
+// "objectClassName" member, so formatWHOIS can pick the right renderer.
+type objectClass struct {
+	ObjectClassName string `json:"objectClassName"`
+}
+
+// formatWHOIS renders res as RFC 3912-style "key: value" WHOIS text. The
+// response is dispatched to a domain, IP network, nameserver or autnum
+// renderer based on its RFC 7483 objectClassName.
+func formatWHOIS(res *dns.Response) (string, error) {
+	raw, err := json.Marshal(res)
+	if err != nil {
+		return "", err
+	}
+
+	var class objectClass
+	if err := json.Unmarshal(raw, &class); err != nil {
+		return "", err
+	}
+
+	switch class.ObjectClassName {
+	case "ip network":
+		var n rdapIPNetwork
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return "", err
+		}
+		return formatIPNetworkWHOIS(n), nil
+	case "nameserver":
+		var ns rdapNameserver
+		if err := json.Unmarshal(raw, &ns); err != nil {
+			return "", err
+		}
+		return formatNameserverWHOIS(ns), nil
+	case "autnum":
+		var a rdapAutnum
+		if err := json.Unmarshal(raw, &a); err != nil {
+			return "", err
+		}
+		return formatAutnumWHOIS(a), nil
+	default:
+		var d rdapDomain
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return "", err
+		}
+		return formatDomainWHOIS(d), nil
+	}
+}
+
+func formatDomainWHOIS(d rdapDomain) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Domain Name: %s\n", d.LdhName)
+	if reg := registrar(d.Entities); reg != "" {
+		fmt.Fprintf(&b, "Registrar: %s\n", reg)
+	}
+	if date := eventDate(d.Events, "registration"); date != "" {
+		fmt.Fprintf(&b, "Creation Date: %s\n", date)
+	}
+	if date := eventDate(d.Events, "expiration"); date != "" {
+		fmt.Fprintf(&b, "Registry Expiry Date: %s\n", date)
+	}
+	if date := eventDate(d.Events, "last changed"); date != "" {
+		fmt.Fprintf(&b, "Updated Date: %s\n", date)
+	}
+	if reg := entityWithRole(d.Entities, "registrant"); reg != "" {
+		fmt.Fprintf(&b, "Registrant: %s\n", reg)
+	}
+	for _, ns := range d.Nameservers {
+		fmt.Fprintf(&b, "Name Server: %s\n", strings.ToUpper(ns.LdhName))
+	}
+	if d.SecureDNS != nil {
+		if d.SecureDNS.DelegationSigned {
+			b.WriteString("DNSSEC: signedDelegation\n")
+		} else {
+			b.WriteString("DNSSEC: unsigned\n")
+		}
+	}
+	for _, status := range d.Status {
+		fmt.Fprintf(&b, "Domain Status: %s\n", eppStatus(status))
+	}
+
+	return b.String()
+}
+
+func formatIPNetworkWHOIS(n rdapIPNetwork) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "NetHandle: %s\n", n.Handle)
+	fmt.Fprintf(&b, "NetRange: %s - %s\n", n.StartAddress, n.EndAddress)
+	if n.Name != "" {
+		fmt.Fprintf(&b, "NetName: %s\n", n.Name)
+	}
+	if n.Type != "" {
+		fmt.Fprintf(&b, "NetType: %s\n", n.Type)
+	}
+	if n.Country != "" {
+		fmt.Fprintf(&b, "Country: %s\n", n.Country)
+	}
+	if org := entityWithRole(n.Entities, "registrant"); org != "" {
+		fmt.Fprintf(&b, "Organization: %s\n", org)
+	}
+	if date := eventDate(n.Events, "registration"); date != "" {
+		fmt.Fprintf(&b, "RegDate: %s\n", date)
+	}
+	if date := eventDate(n.Events, "last changed"); date != "" {
+		fmt.Fprintf(&b, "Updated: %s\n", date)
+	}
+	for _, status := range n.Status {
+		fmt.Fprintf(&b, "Status: %s\n", status)
+	}
+
+	return b.String()
+}
+
+func formatNameserverWHOIS(ns rdapNameserver) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Server Name: %s\n", strings.ToUpper(ns.LdhName))
+	fmt.Fprintf(&b, "Handle: %s\n", ns.Handle)
+	if ns.IPAddresses != nil {
+		for _, ip := range ns.IPAddresses.V4 {
+			fmt.Fprintf(&b, "IP Address: %s\n", ip)
+		}
+		for _, ip := range ns.IPAddresses.V6 {
+			fmt.Fprintf(&b, "IP Address: %s\n", ip)
+		}
+	}
+	if reg := registrar(ns.Entities); reg != "" {
+		fmt.Fprintf(&b, "Registrar: %s\n", reg)
+	}
+	if date := eventDate(ns.Events, "registration"); date != "" {
+		fmt.Fprintf(&b, "Creation Date: %s\n", date)
+	}
+	if date := eventDate(ns.Events, "last changed"); date != "" {
+		fmt.Fprintf(&b, "Updated Date: %s\n", date)
+	}
+	for _, status := range ns.Status {
+		fmt.Fprintf(&b, "Status: %s\n", eppStatus(status))
+	}
+
+	return b.String()
+}
+
+func formatAutnumWHOIS(a rdapAutnum) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ASHandle: %s\n", a.Handle)
+	if a.StartAutnum == a.EndAutnum {
+		fmt.Fprintf(&b, "ASNumber: %d\n", a.StartAutnum)
+	} else {
+		fmt.Fprintf(&b, "ASNumber: %d - %d\n", a.StartAutnum, a.EndAutnum)
+	}
+	if a.Name != "" {
+		fmt.Fprintf(&b, "ASName: %s\n", a.Name)
+	}
+	if a.Type != "" {
+		fmt.Fprintf(&b, "ASType: %s\n", a.Type)
+	}
+	if org := entityWithRole(a.Entities, "registrant"); org != "" {
+		fmt.Fprintf(&b, "Organization: %s\n", org)
+	}
+	if date := eventDate(a.Events, "registration"); date != "" {
+		fmt.Fprintf(&b, "RegDate: %s\n", date)
+	}
+	for _, status := range a.Status {
+		fmt.Fprintf(&b, "Status: %s\n", status)
+	}
+
+	return b.String()
+}
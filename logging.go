@@ -0,0 +1,20 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sync/atomic"
+)
+
+// logger is the process-wide structured logger. It writes JSON to stdout so
+// log lines can be shipped to any collector without a text-parsing step.
+var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// nextRequestID hands out small monotonically increasing request ids for
+// log correlation; it doesn't need to be globally unique, just unique
+// within a single process's lifetime.
+var nextRequestID uint64
+
+func newRequestID() uint64 {
+	return atomic.AddUint64(&nextRequestID, 1)
+}
@@ -1,22 +1,31 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 	"unicode/utf8"
 
 	"github.com/ryanmab/rdap-go/pkg/client"
 	"github.com/ryanmab/rdap-go/pkg/client/response/dns"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 const (
-	MAX_CONN_TIME = 10 * time.Second
+	MAX_CONN_TIME  = 10 * time.Second
 	MAX_REQ_LENGTH = 64
-	ERROR = `
+	ERROR          = `
 @
 @ whois-to-rdap proxy server
 @ There was a critical error
@@ -32,6 +41,62 @@ const (
 No match for "%s".`
 )
 
+// stringList collects repeated flag occurrences into an ordered slice,
+// used for --resolver and --allow.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// resolver is the secure DNS resolver (or fallback chain) used to resolve
+// RDAP bootstrap and server hostnames. It defaults to the OS resolver.
+var resolver Resolver = systemResolver{res: net.DefaultResolver}
+
+// responseCache holds recent RDAP lookups keyed by domain and coalesces
+// concurrent queries for the same domain. It is replaced in main once the
+// --cache-* flags have been parsed.
+var responseCache = NewResponseCache(defaultCacheSize, defaultPositiveTTL, defaultNegativeTTL)
+
+// outputFormat is the server-wide default rendering for successful lookups.
+// A query may opt into JSON for itself with a leading "-J " prefix.
+var outputFormat = FormatWHOIS
+
+// httpClientFor builds an *http.Client whose dialer resolves hostnames
+// through r instead of the OS resolver, so RDAP lookups honor --resolver.
+func httpClientFor(r Resolver) *http.Client {
+	dialer := &net.Dialer{}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		addrs, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var lastErr error
+		for _, ip := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+	return &http.Client{Transport: transport}
+}
+
 // https://gist.github.com/chmike/d4126a3247a6d9a70922fc0e8b4f4013
 func checkDomain(name string) error {
 	switch {
@@ -84,22 +149,55 @@ func checkDomain(name string) error {
 	return nil
 }
 
-func handleSuccess(req string, conn net.Conn) {
-	res, err := rdapQuery(req)
+func handleSuccess(q query, conn net.Conn, format OutputFormat) (string, error) {
+	res, err := responseCache.Lookup(q.value, func() (*dns.Response, error) {
+		upstreamStart := time.Now()
+		var res *dns.Response
+		var err error
+		switch q.kind {
+		case queryIP:
+			res, err = rdapQueryIP(q.value)
+		case queryAutnum:
+			res, err = rdapQueryAutnum(q.value)
+		case queryNameserver:
+			res, err = rdapQueryNameserver(q.value)
+		default:
+			res, err = rdapQueryDomain(q.value)
+		}
+		upstreamDuration.WithLabelValues(q.kind.String()).Observe(time.Since(upstreamStart).Seconds())
+		return res, err
+	})
 	if err != nil {
-		fmt.Fprintf(conn, NO_MATCH + "\n\nEither we don't have the RDAP server for that TLD, or the domain does not exist.", req)
+		fmt.Fprintf(conn, NO_MATCH+"\n\nEither we don't have the RDAP server for that resource, or it does not exist.", q.value)
 		conn.Close()
-		return
+		return "", err
 	}
-	j, err := json.MarshalIndent(res, "", "  ")
-	if err != nil {
-		conn.Write([]byte(ERROR))
-		conn.Close()
-		return
+
+	server := upstreamServer(res)
+
+	out := []byte(HELP)
+	if format == FormatWHOIS || format == FormatBoth {
+		text, err := formatWHOIS(res)
+		if err != nil {
+			conn.Write([]byte(ERROR))
+			conn.Close()
+			return server, err
+		}
+		out = append(out, text...)
+	}
+	if format == FormatJSON || format == FormatBoth {
+		j, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			conn.Write([]byte(ERROR))
+			conn.Close()
+			return server, err
+		}
+		out = append(out, j...)
 	}
 
-	conn.Write(append([]byte(HELP), j...))
+	conn.Write(out)
 	conn.Close()
+	return server, nil
 }
 
 func handleClient(conn net.Conn) {
@@ -107,11 +205,15 @@ func handleClient(conn net.Conn) {
 	request := make([]byte, MAX_REQ_LENGTH)
 	defer conn.Close()
 
+	reqID := newRequestID()
+	remote := conn.RemoteAddr().String()
+	start := time.Now()
+
 	for {
 		readLen, err := conn.Read(request)
 
 		if err != nil {
-			log.Println(err)
+			logger.Info("read failed", "request_id", reqID, "remote_addr", remote, "error", err)
 			break
 		}
 
@@ -120,49 +222,162 @@ func handleClient(conn net.Conn) {
 		} else {
 			req := strings.TrimSpace(string(request[:readLen]))
 
+			format := outputFormat
+			if rest, ok := strings.CutPrefix(req, "-J "); ok {
+				req = strings.TrimSpace(rest)
+				format = FormatJSON
+			}
+
+			var kind, result, server string
 			if req == "help" {
 				conn.Write([]byte(HELP))
 				conn.Close()
+				kind, result = "help", "ok"
 			} else {
-				if err := checkDomain(req); err != nil {
-					fmt.Fprintf(conn, NO_MATCH + "\n\nInvalid domain: %s", req, err.Error())
-					conn.Close()
-				} else {
-					handleSuccess(req, conn)
+				q, _ := parseQuery(req)
+				kind = q.kind.String()
+
+				if q.kind == queryDomain || q.kind == queryNameserver {
+					if err := checkDomain(q.value); err != nil {
+						fmt.Fprintf(conn, NO_MATCH+"\n\nInvalid domain: %s", req, err.Error())
+						conn.Close()
+						result = "invalid"
+					}
+				}
+				if result == "" {
+					var err error
+					server, err = handleSuccess(q, conn, format)
+					if err != nil {
+						result = "error"
+					} else {
+						result = "ok"
+					}
 				}
 			}
+
+			latency := time.Since(start)
+			queriesTotal.WithLabelValues(kind, result).Inc()
+			queryDuration.WithLabelValues(kind).Observe(latency.Seconds())
+			logger.Info("query handled",
+				"request_id", reqID,
+				"remote_addr", remote,
+				"upstream_server", server,
+				"query", req,
+				"kind", kind,
+				"result", result,
+				"latency_ms", latency.Milliseconds(),
+			)
 			break
 		}
 	}
 }
 
-func listen(port string) error {
-	tcpAddr, err := net.ResolveTCPAddr("tcp4", port)
+func rdapQueryDomain(domain string) (*dns.Response, error) {
+	client := client.New(client.WithHTTPClient(httpClientFor(resolver)))
+
+	return client.LookupDomain(domain)
+}
+
+func rdapQueryIP(ip string) (*dns.Response, error) {
+	client := client.New(client.WithHTTPClient(httpClientFor(resolver)))
+
+	return client.LookupIP(ip)
+}
+
+func rdapQueryAutnum(asn string) (*dns.Response, error) {
+	client := client.New(client.WithHTTPClient(httpClientFor(resolver)))
+
+	n, err := strconv.ParseUint(asn, 10, 32)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("invalid AS number %q: %w", asn, err)
 	}
-	listener, err := net.ListenTCP("tcp", tcpAddr)
+
+	return client.LookupAutnum(uint32(n))
+}
+
+func rdapQueryNameserver(host string) (*dns.Response, error) {
+	client := client.New(client.WithHTTPClient(httpClientFor(resolver)))
+
+	return client.LookupNameserver(host)
+}
+
+func main() {
+	var resolvers stringList
+	flag.Var(&resolvers, "resolver", "secure DNS resolver to use for RDAP lookups (https://host/path for DoH, quic://host:port for DoQ); may be repeated to build a fallback chain")
+	resolverFallback := flag.Bool("resolver-fallback", false, "fall back to the OS resolver if every --resolver is unreachable; leave false on a hostile network, since that's the resolver --resolver exists to bypass")
+	cacheSize := flag.Int("cache-size", defaultCacheSize, "maximum number of domains to keep in the RDAP response cache")
+	cacheTTL := flag.Duration("cache-ttl", defaultPositiveTTL, "how long successful RDAP lookups are cached")
+	cacheNegativeTTL := flag.Duration("cache-negative-ttl", defaultNegativeTTL, "how long failed RDAP lookups (NXDOMAIN, no RDAP server) are cached")
+	format := flag.String("format", string(FormatWHOIS), "default response format for successful lookups: whois, json or both")
+	rateLimitRPS := flag.Float64("rate-limit-rps", 5, "maximum sustained queries per second allowed from a single client IP")
+	rateLimitBurst := flag.Int("rate-limit-burst", 10, "maximum burst of queries allowed from a single client IP")
+	var allowlist stringList
+	flag.Var(&allowlist, "allow", "CIDR or IP exempt from rate limiting; may be repeated")
+	metricsAddr := flag.String("metrics-addr", ":9100", "address the /metrics HTTP endpoint listens on")
+	plainAddr := flag.String("plain-addr", defaultPlainAddr, "address the plaintext WHOIS listener binds to, empty to disable")
+	tlsAddr := flag.String("tls-addr", defaultTLSAddr, "address the WHOIS-over-TLS listener binds to, empty to disable")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file for the WHOIS-over-TLS listener")
+	tlsKey := flag.String("tls-key", "", "TLS private key file for the WHOIS-over-TLS listener")
+	acmeDomain := flag.String("acme-domain", "", "domain to request an automatic Let's Encrypt certificate for, instead of --tls-cert/--tls-key")
+	acmeCacheDir := flag.String("acme-cache-dir", "acme-cache", "directory ACME certificates and keys are cached in")
+	acmeHTTPAddr := flag.String("acme-http-addr", defaultACMEHTTPAddr, "address the ACME HTTP-01 challenge listens on; Let's Encrypt only ever dials port 80 for this, so --tls-addr alone is not enough")
+	flag.Parse()
+
+	parsedFormat, err := ParseOutputFormat(*format)
 	if err != nil {
-		return err
+		log.Fatal(err)
 	}
+	outputFormat = parsedFormat
 
-	for {
-		conn, err := listener.Accept()
-
+	if len(resolvers) > 0 {
+		chain, err := NewResolverChain(resolvers, *resolverFallback)
 		if err != nil {
-			continue
+			log.Fatal(err)
 		}
+		resolver = chain
+	}
 
-		go handleClient(conn)
+	responseCache = NewResponseCache(*cacheSize, *cacheTTL, *cacheNegativeTTL)
+
+	allowedNets, err := ParseAllowlist(allowlist)
+	if err != nil {
+		log.Fatal(err)
 	}
-}
+	limiter := NewRateLimiter(*rateLimitRPS, *rateLimitBurst, allowedNets)
 
-func rdapQuery(domain string) (*dns.Response, error) {
-	client := client.New()
+	go func() {
+		logger.Error("metrics server exited", "error", serveMetrics(*metricsAddr))
+	}()
 
-	return client.LookupDomain(domain)
-}
+	var tlsConfig *tls.Config
+	var acmeManager *autocert.Manager
+	switch {
+	case *acmeDomain != "":
+		acmeManager = ACMEManagerFor(*acmeCacheDir, *acmeDomain)
+		tlsConfig = acmeManager.TLSConfig()
+	case *tlsCert != "" && *tlsKey != "":
+		tlsConfig, err = TLSConfigFromFiles(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case *tlsAddr != "":
+		logger.Warn("tls-addr is set but no certificate source was given, disabling the TLS listener", "tls_addr", *tlsAddr)
+		*tlsAddr = ""
+	}
 
-func main() {
-	log.Fatal(listen(":4343"))
+	server := &Server{
+		PlainAddr:    *plainAddr,
+		TLSAddr:      *tlsAddr,
+		TLSConfig:    tlsConfig,
+		Limiter:      limiter,
+		ACMEManager:  acmeManager,
+		ACMEHTTPAddr: *acmeHTTPAddr,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := server.ListenAndServe(ctx); err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
 }
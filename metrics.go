@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics for the proxy's query path. Cache stats are exposed
+// via cacheStatsCollector rather than counters updated inline, since they
+// are already tracked on responseCache.
+var (
+	queriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "whois_to_rdap_queries_total",
+		Help: "Total WHOIS queries handled, by query kind and result.",
+	}, []string{"kind", "result"})
+
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "whois_to_rdap_query_duration_seconds",
+		Help:    "Time to serve a WHOIS query, including any upstream RDAP lookup.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"kind"})
+
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "whois_to_rdap_upstream_duration_seconds",
+		Help:    "Time spent in the upstream RDAP lookup itself, excluding cache hits.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"kind"})
+
+	rateLimitedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "whois_to_rdap_rate_limited_total",
+		Help: "Connections rejected by the per-IP rate limiter.",
+	})
+)
+
+// cacheStatsCollector adapts responseCache.Stats (plain atomic counters) to
+// the prometheus.Collector interface so they show up under /metrics
+// without duplicating state in promauto counters.
+type cacheStatsCollector struct{}
+
+var (
+	cacheHitsDesc      = prometheus.NewDesc("whois_to_rdap_cache_hits_total", "RDAP response cache hits.", nil, nil)
+	cacheMissesDesc    = prometheus.NewDesc("whois_to_rdap_cache_misses_total", "RDAP response cache misses.", nil, nil)
+	cacheCoalescedDesc = prometheus.NewDesc("whois_to_rdap_cache_coalesced_total", "Queries that waited on an in-flight upstream request instead of issuing their own.", nil, nil)
+)
+
+func (cacheStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheHitsDesc
+	ch <- cacheMissesDesc
+	ch <- cacheCoalescedDesc
+}
+
+func (cacheStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&responseCache.Stats.Hits)))
+	ch <- prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&responseCache.Stats.Misses)))
+	ch <- prometheus.MustNewConstMetric(cacheCoalescedDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&responseCache.Stats.Coalesced)))
+}
+
+func init() {
+	prometheus.MustRegister(cacheStatsCollector{})
+}
+
+// serveMetrics starts the /metrics HTTP endpoint and blocks until it fails.
+func serveMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// queryKind identifies the shape of a WHOIS query so handleClient can
+// dispatch it to the matching RDAP lookup.
+type queryKind int
+
+const (
+	queryDomain queryKind = iota
+	queryIP
+	queryAutnum
+	queryNameserver
+)
+
+func (k queryKind) String() string {
+	switch k {
+	case queryIP:
+		return "ip"
+	case queryAutnum:
+		return "autnum"
+	case queryNameserver:
+		return "nameserver"
+	default:
+		return "domain"
+	}
+}
+
+// nameserverPrefix is the explicit query keyword WHOIS servers such as
+// Verisign's use to request nameserver objects (e.g. "nameserver
+// ns1.example.com"), since a bare hostname is otherwise indistinguishable
+// from a domain query.
+const nameserverPrefix = "nameserver "
+
+// query is a parsed WHOIS request: kind plus the normalized value to look
+// up (a hostname, an IP literal, or a bare AS number).
+type query struct {
+	kind  queryKind
+	value string
+}
+
+// asnPattern matches an AS number written with the "AS" prefix WHOIS
+// clients conventionally use, in any case (e.g. "AS15169" or "as15169").
+// A bare number without the prefix does not match.
+var asnPattern = regexp.MustCompile(`(?i)^AS(\d+)$`)
+
+// parseQuery classifies req as a nameserver ("nameserver " prefix), AS
+// number, IP/CIDR, or domain query.
+// Reverse DNS names under in-addr.arpa/ip6.arpa are converted back to the
+// IP address they encode.
+func parseQuery(req string) (query, error) {
+	if _, ok := strings.CutPrefix(strings.ToLower(req), nameserverPrefix); ok {
+		return query{kind: queryNameserver, value: strings.TrimSpace(req[len(nameserverPrefix):])}, nil
+	}
+
+	if m := asnPattern.FindStringSubmatch(req); m != nil {
+		return query{kind: queryAutnum, value: m[1]}, nil
+	}
+
+	if ip, err := reverseArpaToIP(req); err == nil {
+		return query{kind: queryIP, value: ip.String()}, nil
+	}
+
+	if ip := net.ParseIP(req); ip != nil {
+		return query{kind: queryIP, value: ip.String()}, nil
+	}
+
+	if ip, _, err := net.ParseCIDR(req); err == nil {
+		return query{kind: queryIP, value: ip.String()}, nil
+	}
+
+	return query{kind: queryDomain, value: req}, nil
+}
+
+// reverseArpaToIP converts a "W.X.Y.Z.in-addr.arpa." or nibble-format
+// "...ip6.arpa." name back into the IP address it encodes.
+func reverseArpaToIP(name string) (net.IP, error) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+
+	switch {
+	case strings.HasSuffix(name, ".in-addr.arpa"):
+		labels := strings.Split(strings.TrimSuffix(name, ".in-addr.arpa"), ".")
+		if len(labels) != 4 {
+			return nil, fmt.Errorf("malformed in-addr.arpa name %q", name)
+		}
+		octets := make([]byte, 4)
+		for i, label := range labels {
+			n, err := strconv.Atoi(label)
+			if err != nil || n < 0 || n > 255 {
+				return nil, fmt.Errorf("malformed in-addr.arpa octet %q", label)
+			}
+			// labels are in reverse order: last label is the most significant octet
+			octets[3-i] = byte(n)
+		}
+		return net.IPv4(octets[0], octets[1], octets[2], octets[3]), nil
+
+	case strings.HasSuffix(name, ".ip6.arpa"):
+		nibbles := strings.Split(strings.TrimSuffix(name, ".ip6.arpa"), ".")
+		if len(nibbles) != 32 {
+			return nil, fmt.Errorf("malformed ip6.arpa name %q", name)
+		}
+		var ip net.IP = make(net.IP, 16)
+		for i, nibble := range nibbles {
+			if len(nibble) != 1 {
+				return nil, fmt.Errorf("malformed ip6.arpa nibble %q", nibble)
+			}
+			v, err := strconv.ParseUint(nibble, 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("malformed ip6.arpa nibble %q", nibble)
+			}
+			// nibbles are reversed and least-significant-first
+			byteIdx := 15 - i/2
+			if i%2 == 0 {
+				ip[byteIdx] |= byte(v)
+			} else {
+				ip[byteIdx] |= byte(v) << 4
+			}
+		}
+		return ip, nil
+	}
+
+	return nil, fmt.Errorf("%q is not a reverse DNS name", name)
+}
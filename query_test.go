@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestReverseArpaToIP(t *testing.T) {
+	for _, ip := range []string{"192.0.2.1", "8.8.8.8", "2001:db8::1", "::1"} {
+		want := net.ParseIP(ip)
+		arpa := dns.ReverseAddr(ip)
+
+		got, err := reverseArpaToIP(arpa)
+		if err != nil {
+			t.Fatalf("reverseArpaToIP(%q) returned error: %v", arpa, err)
+		}
+		if !got.Equal(want) {
+			t.Errorf("reverseArpaToIP(%q) = %v, want %v", arpa, got, want)
+		}
+	}
+}
+
+func TestReverseArpaToIPMalformed(t *testing.T) {
+	badIP6Nibble := "g." + strings.Repeat("0.", 31) + "ip6.arpa"
+	cases := []string{
+		"example.com",
+		"1.2.3.in-addr.arpa",     // too few octets
+		"1.2.3.4.5.in-addr.arpa", // too many octets
+		"1.2.3.256.in-addr.arpa", // out-of-range octet
+		badIP6Nibble,             // bad nibble
+	}
+	for _, name := range cases {
+		if _, err := reverseArpaToIP(name); err == nil {
+			t.Errorf("reverseArpaToIP(%q) = nil error, want an error", name)
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	cases := []struct {
+		req      string
+		wantKind queryKind
+		wantVal  string
+	}{
+		{"example.com", queryDomain, "example.com"},
+		{"192.0.2.1", queryIP, "192.0.2.1"},
+		{"192.0.2.0/24", queryIP, "192.0.2.0"},
+		{"2001:db8::1", queryIP, "2001:db8::1"},
+		{"AS15169", queryAutnum, "15169"},
+		{"as15169", queryAutnum, "15169"},
+		{dns.ReverseAddr("192.0.2.1"), queryIP, "192.0.2.1"},
+		{"nameserver ns1.example.com", queryNameserver, "ns1.example.com"},
+		{"NAMESERVER ns1.example.com", queryNameserver, "ns1.example.com"},
+	}
+	for _, c := range cases {
+		q, err := parseQuery(c.req)
+		if err != nil {
+			t.Fatalf("parseQuery(%q) returned error: %v", c.req, err)
+		}
+		if q.kind != c.wantKind || q.value != c.wantVal {
+			t.Errorf("parseQuery(%q) = {%v, %q}, want {%v, %q}", c.req, q.kind, q.value, c.wantKind, c.wantVal)
+		}
+	}
+}
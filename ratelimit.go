@@ -0,0 +1,107 @@
+package main
+
+import (
+	"container/list"
+	"net"
+	"strconv"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// maxTrackedIPs bounds how many per-IP limiters RateLimiter keeps at once.
+// Without a cap, a client rotating or spoofing source addresses could grow
+// rl.limiters without bound and turn the limiter itself into a memory
+// exhaustion vector, so the least recently seen IP is evicted once the
+// cap is reached.
+const maxTrackedIPs = 100_000
+
+// limiterEntry pairs a per-IP limiter with its position in the LRU list.
+type limiterEntry struct {
+	limiter *rate.Limiter
+	elem    *list.Element // Value is the IP key
+}
+
+// RateLimiter enforces a token-bucket rate limit per client IP, with an
+// optional CIDR allowlist that bypasses the limit entirely (e.g. for
+// monitoring or known-good upstreams). Per-IP state is kept in a bounded
+// LRU so an unbounded number of distinct source IPs can't grow it forever.
+type RateLimiter struct {
+	mu        sync.Mutex
+	limiters  map[string]*limiterEntry
+	order     *list.List // front = most recently seen
+	capacity  int
+	rps       rate.Limit
+	burst     int
+	allowlist []*net.IPNet
+}
+
+// NewRateLimiter builds a RateLimiter allowing rps queries per second per IP,
+// with bursts up to burst. It tracks at most maxTrackedIPs distinct IPs,
+// evicting the least recently seen once that cap is reached.
+func NewRateLimiter(rps float64, burst int, allowlist []*net.IPNet) *RateLimiter {
+	return &RateLimiter{
+		limiters:  make(map[string]*limiterEntry),
+		order:     list.New(),
+		capacity:  maxTrackedIPs,
+		rps:       rate.Limit(rps),
+		burst:     burst,
+		allowlist: allowlist,
+	}
+}
+
+// Allow reports whether a query from ip should proceed. It always returns
+// true for addresses covered by the allowlist.
+func (rl *RateLimiter) Allow(ip net.IP) bool {
+	for _, n := range rl.allowlist {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	key := ip.String()
+
+	rl.mu.Lock()
+	entry, ok := rl.limiters[key]
+	if ok {
+		rl.order.MoveToFront(entry.elem)
+	} else {
+		entry = &limiterEntry{limiter: rate.NewLimiter(rl.rps, rl.burst)}
+		entry.elem = rl.order.PushFront(key)
+		rl.limiters[key] = entry
+
+		for rl.order.Len() > rl.capacity {
+			oldest := rl.order.Back()
+			if oldest == nil {
+				break
+			}
+			rl.order.Remove(oldest)
+			delete(rl.limiters, oldest.Value.(string))
+		}
+	}
+	limiter := entry.limiter
+	rl.mu.Unlock()
+
+	return limiter.Allow()
+}
+
+// ParseAllowlist parses a list of CIDR strings (bare IPs are treated as
+// /32 or /128) into the form NewRateLimiter expects.
+func ParseAllowlist(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, s := range cidrs {
+		if ip := net.ParseIP(s); ip != nil {
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			s = ip.String() + "/" + strconv.Itoa(bits)
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseAllowlist(t *testing.T) {
+	nets, err := ParseAllowlist([]string{"192.0.2.0/24", "203.0.113.5", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("ParseAllowlist returned error: %v", err)
+	}
+	if len(nets) != 3 {
+		t.Fatalf("ParseAllowlist returned %d nets, want 3", len(nets))
+	}
+
+	cases := []struct {
+		ip   string
+		want bool
+	}{
+		{"192.0.2.1", true},
+		{"192.0.3.1", false},
+		{"203.0.113.5", true},
+		{"203.0.113.6", false},
+		{"2001:db8::1", true},
+		{"2001:db8::2", false},
+	}
+	for _, c := range cases {
+		ip := net.ParseIP(c.ip)
+		var contained bool
+		for _, n := range nets {
+			if n.Contains(ip) {
+				contained = true
+				break
+			}
+		}
+		if contained != c.want {
+			t.Errorf("allowlist contains %q = %v, want %v", c.ip, contained, c.want)
+		}
+	}
+}
+
+func TestParseAllowlistInvalid(t *testing.T) {
+	if _, err := ParseAllowlist([]string{"not-a-cidr"}); err == nil {
+		t.Error("ParseAllowlist(invalid) = nil error, want an error")
+	}
+}
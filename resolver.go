@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// resolverTimeout bounds a single upstream resolution attempt, independent of
+// MAX_CONN_TIME which governs the whole client connection.
+const resolverTimeout = 5 * time.Second
+
+// Resolver looks up the A/AAAA records for host and returns their string
+// addresses, mirroring the subset of *net.Resolver that httpClientFor needs.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// systemResolver defers to the OS resolver and is used when no --resolver
+// flag is given, or as the final link of a fallback chain.
+type systemResolver struct {
+	res *net.Resolver
+}
+
+func (r systemResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return r.res.LookupHost(ctx, host)
+}
+
+// chainResolver tries each Resolver in order, falling through to the next on
+// error so a misbehaving or unreachable secure resolver doesn't take down
+// the whole proxy.
+type chainResolver struct {
+	resolvers []Resolver
+}
+
+func (c chainResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	var lastErr error
+	for _, r := range c.resolvers {
+		addrs, err := r.LookupHost(ctx, host)
+		if err == nil && len(addrs) > 0 {
+			return addrs, nil
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no resolver in chain returned an address for %q", host)
+	}
+	return nil, lastErr
+}
+
+// dohResolver implements DNS-over-HTTPS (RFC 8484) against a single
+// endpoint, e.g. https://cloudflare-dns.com/dns-query.
+type dohResolver struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newDoHResolver(endpoint string) *dohResolver {
+	return &dohResolver{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: resolverTimeout},
+	}
+}
+
+func (d *dohResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	fqdn := dns.Fqdn(host)
+
+	var addrs []string
+	var lastErr error
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg := new(dns.Msg)
+		msg.SetQuestion(fqdn, qtype)
+		msg.Id = dns.Id()
+
+		packed, err := msg.Pack()
+		if err != nil {
+			lastErr = fmt.Errorf("doh: packing query for %s: %w", host, err)
+			continue
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint, bytes.NewReader(packed))
+		if err != nil {
+			lastErr = fmt.Errorf("doh: building request to %s: %w", d.endpoint, err)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/dns-message")
+		req.Header.Set("Accept", "application/dns-message")
+
+		resp, err := d.client.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("doh: querying %s: %w", d.endpoint, err)
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = fmt.Errorf("doh: reading response from %s: %w", d.endpoint, err)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			lastErr = fmt.Errorf("doh: %s returned status %d", d.endpoint, resp.StatusCode)
+			continue
+		}
+
+		reply := new(dns.Msg)
+		if err := reply.Unpack(body); err != nil {
+			lastErr = fmt.Errorf("doh: unpacking response from %s: %w", d.endpoint, err)
+			continue
+		}
+		addrs = append(addrs, addressesFromAnswer(reply.Answer)...)
+	}
+
+	if len(addrs) == 0 {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, fmt.Errorf("doh: %s has no A/AAAA records", host)
+	}
+	return addrs, nil
+}
+
+// doqResolver implements DNS-over-QUIC (RFC 9250) against a single
+// quic://host:port endpoint.
+type doqResolver struct {
+	addr string
+}
+
+func newDoQResolver(addr string) *doqResolver {
+	return &doqResolver{addr: addr}
+}
+
+// doqALPN is the ALPN token assigned to DNS-over-QUIC by RFC 9250.
+const doqALPN = "doq"
+
+func (d *doqResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, resolverTimeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	tlsConf := &tls.Config{NextProtos: []string{doqALPN}}
+	conn, err := quic.DialAddr(ctx, d.addr, tlsConf, nil)
+	if err != nil {
+		return nil, fmt.Errorf("doq: dialing %s: %w", d.addr, err)
+	}
+	defer conn.CloseWithError(0, "")
+
+	fqdn := dns.Fqdn(host)
+	var addrs []string
+	var lastErr error
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		stream, err := conn.OpenStreamSync(ctx)
+		if err != nil {
+			lastErr = fmt.Errorf("doq: opening stream to %s: %w", d.addr, err)
+			continue
+		}
+		stream.SetDeadline(deadline)
+
+		msg := new(dns.Msg)
+		msg.SetQuestion(fqdn, qtype)
+		msg.Id = 0 // RFC 9250 section 4.2.1: the message ID MUST be 0 on the wire
+
+		packed, err := msg.Pack()
+		if err != nil {
+			stream.Close()
+			lastErr = fmt.Errorf("doq: packing query for %s: %w", host, err)
+			continue
+		}
+
+		var lenPrefix [2]byte
+		binary.BigEndian.PutUint16(lenPrefix[:], uint16(len(packed)))
+		if _, err := stream.Write(append(lenPrefix[:], packed...)); err != nil {
+			stream.Close()
+			lastErr = fmt.Errorf("doq: writing query to %s: %w", d.addr, err)
+			continue
+		}
+		stream.Close() // signals end of the client's side of the stream per RFC 9250
+
+		respLenPrefix := make([]byte, 2)
+		if _, err := io.ReadFull(stream, respLenPrefix); err != nil {
+			lastErr = fmt.Errorf("doq: reading response length from %s: %w", d.addr, err)
+			continue
+		}
+		respLen := binary.BigEndian.Uint16(respLenPrefix)
+		respBody := make([]byte, respLen)
+		if _, err := io.ReadFull(stream, respBody); err != nil {
+			lastErr = fmt.Errorf("doq: reading response from %s: %w", d.addr, err)
+			continue
+		}
+
+		reply := new(dns.Msg)
+		if err := reply.Unpack(respBody); err != nil {
+			lastErr = fmt.Errorf("doq: unpacking response from %s: %w", d.addr, err)
+			continue
+		}
+		addrs = append(addrs, addressesFromAnswer(reply.Answer)...)
+	}
+
+	if len(addrs) == 0 {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, fmt.Errorf("doq: %s has no A/AAAA records", host)
+	}
+	return addrs, nil
+}
+
+func addressesFromAnswer(rrs []dns.RR) []string {
+	var addrs []string
+	for _, rr := range rrs {
+		switch r := rr.(type) {
+		case *dns.A:
+			addrs = append(addrs, r.A.String())
+		case *dns.AAAA:
+			addrs = append(addrs, r.AAAA.String())
+		}
+	}
+	return addrs
+}
+
+// NewResolver builds a Resolver for a single --resolver flag value. Accepted
+// forms are "https://host/path" for DoH, "quic://host:port" for DoQ, and the
+// empty string for the OS resolver.
+func NewResolver(spec string) (Resolver, error) {
+	if spec == "" {
+		return systemResolver{res: net.DefaultResolver}, nil
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resolver %q: %w", spec, err)
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+		return newDoHResolver(spec), nil
+	case "quic":
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "853")
+		}
+		return newDoQResolver(addr), nil
+	default:
+		return nil, fmt.Errorf("unsupported resolver scheme %q, want https:// or quic://", u.Scheme)
+	}
+}
+
+// NewResolverChain builds a fallback chain from --resolver flag values in
+// the order they were given. The OS resolver is only appended as a last
+// resort when allowOSFallback is true; operators resolving on a hostile
+// network should leave it false; an attacker who blackholes the secure
+// resolvers should make lookups fail, not fall back to the OS resolver
+// being evaded in the first place.
+func NewResolverChain(specs []string, allowOSFallback bool) (Resolver, error) {
+	resolvers := make([]Resolver, 0, len(specs)+1)
+	for _, spec := range specs {
+		r, err := NewResolver(spec)
+		if err != nil {
+			return nil, err
+		}
+		resolvers = append(resolvers, r)
+	}
+	if allowOSFallback {
+		resolvers = append(resolvers, systemResolver{res: net.DefaultResolver})
+	}
+	return chainResolver{resolvers: resolvers}, nil
+}
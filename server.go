@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// defaultPlainAddr and defaultTLSAddr are the standard WHOIS ports: 43 for
+// plaintext (RFC 3912) and 4343, which IANA assigns to WHOIS-over-TLS.
+//
+// defaultACMEHTTPAddr is the port ACME's HTTP-01 challenge must be served
+// on (RFC 8555 requires port 80); it has nothing to do with the WHOIS
+// ports above.
+const (
+	defaultPlainAddr    = ":43"
+	defaultTLSAddr      = ":4343"
+	defaultACMEHTTPAddr = ":80"
+)
+
+// Server runs the plaintext and TLS WHOIS listeners side by side, sharing
+// the same handleClient pipeline, and drains in-flight connections on
+// shutdown instead of dropping them.
+type Server struct {
+	PlainAddr string
+	TLSAddr   string
+	TLSConfig *tls.Config // nil disables the TLS listener
+	Limiter   *RateLimiter
+
+	// ACMEManager, when set, is used to serve the HTTP-01 challenge on
+	// ACMEHTTPAddr so ACMEManagerFor's certificates can actually be
+	// issued and renewed (Let's Encrypt validates HTTP-01 on port 80).
+	ACMEManager  *autocert.Manager
+	ACMEHTTPAddr string
+
+	listeners []net.Listener
+	acmeHTTP  *http.Server
+	wg        sync.WaitGroup
+}
+
+// TLSConfigFromFiles builds a *tls.Config from a certificate/key pair on
+// disk, for operators terminating TLS with their own certificates.
+func TLSConfigFromFiles(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// ACMEManagerFor builds an autocert.Manager that obtains and renews
+// certificates automatically from an ACME CA (e.g. Let's Encrypt) for the
+// given domains, caching them under cacheDir. Its TLSConfig() satisfies
+// TLS-ALPN-01 directly on the TLS listener; its HTTPHandler must also be
+// served on port 80 (see Server.ACMEHTTPAddr) for HTTP-01 to succeed, since
+// an ACME CA only ever dials the well-known ACME ports.
+func ACMEManagerFor(cacheDir string, domains ...string) *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+	}
+}
+
+// ListenAndServe starts every configured listener and blocks until ctx is
+// canceled, at which point it stops accepting new connections and waits
+// for in-flight handleClient goroutines to finish before returning.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	if s.PlainAddr != "" {
+		ln, err := net.Listen("tcp4", s.PlainAddr)
+		if err != nil {
+			return err
+		}
+		s.listeners = append(s.listeners, ln)
+		go s.serve(ln)
+	}
+
+	if s.TLSAddr != "" && s.TLSConfig != nil {
+		ln, err := tls.Listen("tcp4", s.TLSAddr, s.TLSConfig)
+		if err != nil {
+			s.Close()
+			return err
+		}
+		s.listeners = append(s.listeners, ln)
+		go s.serve(ln)
+	}
+
+	if s.ACMEManager != nil {
+		addr := s.ACMEHTTPAddr
+		if addr == "" {
+			addr = defaultACMEHTTPAddr
+		}
+		s.acmeHTTP = &http.Server{Addr: addr, Handler: s.ACMEManager.HTTPHandler(nil)}
+		go s.acmeHTTP.ListenAndServe()
+	}
+
+	<-ctx.Done()
+	s.Close()
+	s.wg.Wait()
+	return ctx.Err()
+}
+
+// Close stops every listener from accepting new connections. It does not
+// wait for in-flight connections; callers that need that should call
+// ListenAndServe's blocking form instead.
+func (s *Server) Close() {
+	for _, ln := range s.listeners {
+		ln.Close()
+	}
+	if s.acmeHTTP != nil {
+		s.acmeHTTP.Close()
+	}
+}
+
+func (s *Server) serve(ln net.Listener) {
+	var retryDelay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// A transient error (e.g. the process hitting its file
+			// descriptor limit) shouldn't take the whole listener down;
+			// back off briefly and keep accepting, the same way
+			// net/http.Server does.
+			if retryDelay == 0 {
+				retryDelay = 5 * time.Millisecond
+			} else {
+				retryDelay *= 2
+			}
+			if max := time.Second; retryDelay > max {
+				retryDelay = max
+			}
+			logger.Error("accept failed, retrying", "addr", ln.Addr().String(), "err", err, "retry_in", retryDelay)
+			time.Sleep(retryDelay)
+			continue
+		}
+		retryDelay = 0
+
+		if s.Limiter != nil {
+			host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+			if err != nil || !s.Limiter.Allow(net.ParseIP(host)) {
+				rateLimitedTotal.Inc()
+				conn.Close()
+				continue
+			}
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			handleClient(conn)
+		}()
+	}
+}